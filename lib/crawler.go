@@ -10,18 +10,20 @@ import (
 	"math"
 	"mime"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"os/signal"
 	"path"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/cheggaaa/pb"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/goware/urlx"
-	"github.com/jackdanger/collectlinks"
 	"github.com/jcelliott/lumber"
-	"github.com/schollz/boltdb-server/connect"
 )
 
 // Crawler is the crawler instance
@@ -40,20 +42,92 @@ type Crawler struct {
 	Remote, Username, Password string // Parameters for BoltDB remote connection
 	TimeIntervalToPrintStats   int
 	TimeIntervalToBackupDB     int
-	numTrash                   int
-	numDone                    int
-	numToDo                    int
-	numberOfURLSParsed         int
-	conn                       *connect.Connection
-	log                        *lumber.ConsoleLogger
+	// OutputWARC, if set, is the directory crawl-NNNNN.warc.gz segments
+	// are written to for every page Download saves, in addition to the
+	// per-URL gzipped files under downloaded/, which are still written.
+	// Crawl does not write WARC records.
+	OutputWARC string
+	// WARCRotateSize overrides the size, in bytes, at which a WARC
+	// segment under OutputWARC is rotated into a new file. Zero uses
+	// WARCWriter's default of 1 GiB.
+	WARCRotateSize int64
+	// ObeyRobots, if true, makes the crawler fetch and honor each
+	// host's robots.txt before downloading or crawling any of its URLs.
+	ObeyRobots bool
+	// UserAgent is sent on outbound requests and matched against
+	// robots.txt groups. Defaults to defaultUserAgent.
+	UserAgent string
+	// PerHostQPS caps the fetch rate for any single host, regardless of
+	// MaxNumberWorkers. A robots.txt Crawl-Delay longer than 1/PerHostQPS
+	// takes precedence. Zero disables the cap (Crawl-Delay still applies
+	// when ObeyRobots is set). The cap is enforced only when ObeyRobots
+	// is set; without it no per-host limit is applied.
+	PerHostQPS float64
+	// NoProgress disables the live progress bar, e.g. for non-TTY use,
+	// falling back to periodic stats logging.
+	NoProgress bool
+	// Silent suppresses both the progress bar and stats logging.
+	Silent bool
+	// MaxDepth bounds how many link hops from the seed URL the crawler
+	// will follow. Zero means unlimited.
+	MaxDepth           int
+	numTrash           int
+	numDone            int
+	numToDo            int
+	numberOfURLSParsed int
+	conn               Store
+	log                *lumber.ConsoleLogger
+	warc               *WARCWriter
+	extractors         map[string]LinkExtractor
+	extractorOrder     []string
+	robots             *robotsGate
+	bar                *pb.ProgressBar
+	stopping           int32
 }
 
 func encodeURL(url string) string {
 	return base32.StdEncoding.EncodeToString([]byte(url))
 }
 
-// New will create a new crawler
-func New(url string, boltdbserver string, trace bool) (*Crawler, error) {
+// advanceBar increments the progress bar, if one is active.
+func (c *Crawler) advanceBar() {
+	if c.bar != nil {
+		c.bar.Increment()
+	}
+}
+
+// waitForWorkers waits for the current batch of workers to finish,
+// giving up after timeout so a hung fetch can't wedge the crawler
+// forever. fetchTimeout already bounds each individual request; this
+// is a backstop for whatever that doesn't cover (DNS, slow body reads
+// past the client timeout's proxying, etc).
+func (c *Crawler) waitForWorkers(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		c.log.Error("Timed out after %s waiting for in-flight fetches", timeout)
+	}
+}
+
+// userAgent returns c.UserAgent, falling back to defaultUserAgent.
+func (c *Crawler) userAgent() string {
+	if c.UserAgent == "" {
+		return defaultUserAgent
+	}
+	return c.UserAgent
+}
+
+// New will create a new crawler. storeURI selects the Store backend:
+// "bolt://path/to.db" for an embedded local BoltDB file, "mem://" for
+// an in-memory store, "boltserver://host:port" for a remote
+// boltdb-server, or a bare "host:port" for backwards compatibility with
+// the old boltdb-server-only API.
+func New(url string, storeURI string, trace bool) (*Crawler, error) {
 	var err error
 	c := new(Crawler)
 	if trace {
@@ -68,8 +142,9 @@ func New(url string, boltdbserver string, trace bool) (*Crawler, error) {
 	c.TimeIntervalToPrintStats = 5
 	c.TimeIntervalToBackupDB = 5
 	c.Remote = ""
-	c.log.Info("Creating new database on %s: %s.db", boltdbserver, encodeURL(url))
-	c.conn, err = connect.Open(boltdbserver, encodeURL(url))
+	c.registerDefaultExtractors()
+	c.log.Info("Creating new database on %s: %s.db", storeURI, encodeURL(url))
+	c.conn, err = openStore(storeURI, encodeURL(url))
 	if err != nil {
 		return c, err
 	}
@@ -104,26 +179,47 @@ func (c *Crawler) Name() string {
 	return encodeURL(c.BaseURL)
 }
 
+// LinkInfo describes one URL seen by the crawler, including its
+// position in the crawl tree so callers can reconstruct it.
+type LinkInfo struct {
+	URL    string
+	Parent string
+	Depth  int
+}
+
 func (c *Crawler) GetLinks() (links []string, err error) {
-	doneLinks, err := c.conn.GetAll("done")
+	infos, err := c.GetLinksWithInfo()
 	if err != nil {
 		return links, err
 	}
+	links = make([]string, len(infos))
+	for i, info := range infos {
+		links[i] = info.URL
+	}
+	return links, nil
+}
+
+// GetLinksWithInfo returns every URL in the done and todo buckets along
+// with its depth and discovering parent URL.
+func (c *Crawler) GetLinksWithInfo() (infos []LinkInfo, err error) {
+	doneLinks, err := c.conn.GetAll("done")
+	if err != nil {
+		return infos, err
+	}
 	todoLinks, err := c.conn.GetAll("todo")
 	if err != nil {
-		return links, err
+		return infos, err
 	}
-	links = make([]string, len(doneLinks)+len(todoLinks))
-	linksI := 0
-	for link := range doneLinks {
-		links[linksI] = link
-		linksI++
+	infos = make([]LinkInfo, 0, len(doneLinks)+len(todoLinks))
+	for link, value := range doneLinks {
+		entry := decodeQueueEntry(value)
+		infos = append(infos, LinkInfo{URL: link, Parent: entry.Parent, Depth: entry.Depth})
 	}
-	for link := range todoLinks {
-		links[linksI] = link
-		linksI++
+	for link, value := range todoLinks {
+		entry := decodeQueueEntry(value)
+		infos = append(infos, LinkInfo{URL: link, Parent: entry.Parent, Depth: entry.Depth})
 	}
-	return links, nil
+	return infos, nil
 }
 
 func (c *Crawler) Dump() error {
@@ -140,25 +236,82 @@ func (c *Crawler) Dump() error {
 	return nil
 }
 
-func (c *Crawler) downloadOrCrawlLink(url string, currentNumberOfTries int, download bool) error {
+// enqueueCandidates posts candidates to the todo bucket as children of
+// parent at childDepth, skipping any already known to the todo/trash/done
+// buckets and those that would exceed MaxDepth.
+func (c *Crawler) enqueueCandidates(candidates []string, childDepth int, parent string) error {
+	if c.MaxDepth > 0 && childDepth > c.MaxDepth {
+		c.log.Trace("Not enqueueing links from %s: MaxDepth %d reached", parent, c.MaxDepth)
+		return nil
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	doesHaveKeysMap, err := c.conn.HasKeys([]string{"todo", "trash", "done"}, candidates)
+	if err != nil {
+		return err
+	}
+	linksToDo := make(map[string]string)
+	for link, alreadyDone := range doesHaveKeysMap {
+		if alreadyDone {
+			continue
+		}
+		linksToDo[link] = encodeQueueEntry(queueEntry{Depth: childDepth, Parent: parent})
+		c.numToDo++
+	}
+	if len(linksToDo) == 0 {
+		return nil
+	}
+	c.log.Trace("Posting %d more links todo", len(linksToDo))
+	return c.conn.Post("todo", linksToDo)
+}
+
+func (c *Crawler) downloadOrCrawlLink(url string, entry queueEntry, download bool) error {
 	// Decrement the counter when the goroutine completes.
 	defer c.wg.Done()
 
+	if c.robots != nil {
+		allowed := c.robots.allow(url, c.userAgent(), c.PerHostQPS)
+		if sitemaps := c.robots.takeSitemaps(url); len(sitemaps) > 0 {
+			if err := c.enqueueCandidates(sitemaps, entry.Depth+1, url); err != nil {
+				c.log.Error("Problem enqueueing robots.txt sitemaps for %s: %s", url, err.Error())
+			}
+		}
+		if !allowed {
+			c.log.Trace("Disallowed by robots.txt, trashing %s", url)
+			entry.Reason = "robots-disallowed"
+			if err := c.conn.Post("trash", map[string]string{url: encodeQueueEntry(entry)}); err != nil {
+				return err
+			}
+			c.numTrash++
+			c.numToDo--
+			c.advanceBar()
+			return nil
+		}
+	}
+
 	if download {
 		// Check if it is already downloaded and exists as a file
 		if _, ok := c.curFileList[encodeURL(url)]; ok {
 			c.log.Trace("Already downloaded %s", url)
-			c.conn.Post("done", map[string]string{url: strconv.Itoa(currentNumberOfTries)})
+			c.conn.Post("done", map[string]string{url: encodeQueueEntry(entry)})
 			return nil
 		}
 	}
 
 	// Try to download
-	currentNumberOfTries++
-	resp, err := c.client.Get(url)
+	entry.Tries++
+	fetchedAt := time.Now()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", c.userAgent())
+	resp, err := c.client.Do(req)
 	if err != nil {
 		// Post to trash immedietly if the download fails
-		err2 := c.conn.Post("trash", map[string]string{url: strconv.Itoa(currentNumberOfTries)})
+		err2 := c.conn.Post("trash", map[string]string{url: encodeQueueEntry(entry)})
 		if err2 != nil {
 			return err
 		}
@@ -188,6 +341,12 @@ func (c *Crawler) downloadOrCrawlLink(url string, currentNumberOfTries int, down
 				return err
 			}
 
+			if c.warc != nil {
+				if err := c.warc.WriteExchange(url, resp.Request, resp, fileContent, fetchedAt); err != nil {
+					c.log.Error("Problem writing WARC record for %s: %s", url, err.Error())
+				}
+			}
+
 			var buf bytes.Buffer
 			writer := gzip.NewWriter(&buf)
 			writer.Write(fileContent)
@@ -201,7 +360,15 @@ func (c *Crawler) downloadOrCrawlLink(url string, currentNumberOfTries int, down
 
 			c.log.Trace("Saved %s to %s", url, encodeURL(url)+extension)
 		} else {
-			links := collectlinks.All(resp.Body)
+			var links []string
+			base, parseErr := neturl.Parse(url)
+			if extractor := c.extractorFor(resp.Header.Get("Content-Type")); extractor != nil && parseErr == nil {
+				links, err = extractor.Extract(resp, resp.Body, base)
+				if err != nil {
+					c.log.Error("Problem extracting links from %s: %s", url, err.Error())
+					links = nil
+				}
+			}
 			c.log.Info("Got %d links from %s\n", len(links), url)
 			linkCandidates := make([]string, len(links))
 			linkCandidatesI := 0
@@ -214,8 +381,11 @@ func (c *Crawler) downloadOrCrawlLink(url string, currentNumberOfTries int, down
 				if !strings.Contains(link, "http") {
 					link = c.BaseURL + link
 				}
-				// Skip links that have a different Base URL
-				if !strings.Contains(link, c.BaseURL) {
+				// Skip links that have a different Base URL, unless they
+				// look like a page asset (image/script/stylesheet/font):
+				// those are routinely served from a different host (CDN,
+				// asset subdomain) than the page that references them.
+				if !strings.Contains(link, c.BaseURL) && !isAssetLink(link) {
 					c.log.Trace("Skipping %s because it has a different base URL", link)
 					continue
 				}
@@ -257,49 +427,35 @@ func (c *Crawler) downloadOrCrawlLink(url string, currentNumberOfTries int, down
 			}
 			linkCandidates = linkCandidates[0:linkCandidatesI]
 
-			// Check to see if any link candidates have already been done
-			doesHaveKeysMap, err := c.conn.HasKeys([]string{"todo", "trash", "done"}, linkCandidates)
-			if err != nil {
+			if err := c.enqueueCandidates(linkCandidates, entry.Depth+1, url); err != nil {
 				return err
 			}
-			linksToDo := make(map[string]string)
-			for link, alreadyDone := range doesHaveKeysMap {
-				if alreadyDone {
-					continue
-				}
-				linksToDo[link] = "0"
-				c.numToDo++
-			}
-			// Post new links to todo list
-			c.log.Trace("Posting %d more links todo", len(linksToDo))
-			err = c.conn.Post("todo", linksToDo)
-			if err != nil {
-				return err
-			}
-
 		}
 
 		// Dequeue the current URL
-		err = c.conn.Post("done", map[string]string{url: strconv.Itoa(currentNumberOfTries)})
+		err = c.conn.Post("done", map[string]string{url: encodeQueueEntry(entry)})
 		if err != nil {
 			c.log.Error("Problem posting to done: %s", err.Error())
 		}
 		c.log.Trace("Posted %s to done", url)
 		c.numDone++
 		c.numToDo--
+		c.advanceBar()
 	} else {
-		if currentNumberOfTries > 3 {
+		if entry.Tries > 3 {
 			// Delete this URL as it has been tried too many times
-			err = c.conn.Post("trash", map[string]string{url: strconv.Itoa(currentNumberOfTries)})
+			entry.Reason = "too-many-tries"
+			err = c.conn.Post("trash", map[string]string{url: encodeQueueEntry(entry)})
 			if err != nil {
 				c.log.Error("Problem posting to trash: %s", err.Error())
 			}
 			c.numTrash++
 			c.numToDo--
+			c.advanceBar()
 			c.log.Trace("Too many tries, trashing " + url)
 		} else {
 			// Update the URL with the number of tries
-			m := map[string]string{url: strconv.Itoa(currentNumberOfTries)}
+			m := map[string]string{url: encodeQueueEntry(entry)}
 			c.conn.Post("todo", m)
 		}
 	}
@@ -332,7 +488,7 @@ func (c *Crawler) Download(urls []string) error {
 		if alreadyAdded {
 			continue
 		}
-		urlsStillToDo[url] = "0"
+		urlsStillToDo[url] = encodeQueueEntry(queueEntry{})
 	}
 	if len(urlsStillToDo) > 0 {
 		c.conn.Post("todo", urlsStillToDo)
@@ -354,7 +510,7 @@ func (c *Crawler) Crawl() error {
 		if alreadyAdded {
 			continue
 		}
-		urlsStillToDo[url] = "0"
+		urlsStillToDo[url] = encodeQueueEntry(queueEntry{})
 	}
 	if len(urlsStillToDo) > 0 {
 		c.log.Trace("Posting todo: %v", urlsStillToDo)
@@ -364,6 +520,42 @@ func (c *Crawler) Crawl() error {
 	return c.downloadOrCrawl(download)
 }
 
+// fetchTimeout bounds a single URL fetch so a hung connection can't
+// wedge a worker goroutine (and, in turn, wg.Wait) forever.
+const fetchTimeout = 60 * time.Second
+
+// shutdownWaitBuffer is added on top of fetchTimeout and any per-host
+// throttling delay to get the final batch wait timeout. It only needs
+// to cover scheduling/GC jitter, not a real source of expected delay.
+const shutdownWaitBuffer = 10 * time.Second
+
+// batchWaitTimeout bounds how long downloadOrCrawl will wait for a
+// batch of batchSize in-flight workers to finish, before giving up on
+// a graceful wait. It must exceed fetchTimeout (the per-request bound)
+// plus the worst-case serialization delay PerHostQPS or a robots.txt
+// Crawl-Delay can impose across a full batch hitting the same host --
+// otherwise the wait times out on every batch as soon as a caller
+// enables polite per-host throttling, even though no fetch actually
+// hung.
+func (c *Crawler) batchWaitTimeout(batchSize int) time.Duration {
+	interval := c.perHostInterval()
+	if c.robots != nil {
+		if robotsInterval := c.robots.maxInterval(); robotsInterval > interval {
+			interval = robotsInterval
+		}
+	}
+	return fetchTimeout + time.Duration(batchSize)*interval + shutdownWaitBuffer
+}
+
+// perHostInterval returns the minimum spacing PerHostQPS enforces
+// between fetches to a single host, or 0 if PerHostQPS is unset.
+func (c *Crawler) perHostInterval() time.Duration {
+	if c.PerHostQPS <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / c.PerHostQPS)
+}
+
 func (c *Crawler) downloadOrCrawl(download bool) error {
 	// Generate the connection pool
 	tr := &http.Transport{
@@ -371,13 +563,61 @@ func (c *Crawler) downloadOrCrawl(download bool) error {
 		IdleConnTimeout:    30 * time.Second,
 		DisableCompression: true,
 	}
-	c.client = &http.Client{Transport: tr}
+	c.client = &http.Client{Transport: tr, Timeout: fetchTimeout}
+
+	if c.OutputWARC != "" && c.warc == nil {
+		c.warc = NewWARCWriter(c.OutputWARC)
+		if c.WARCRotateSize > 0 {
+			c.warc.RotateSize = c.WARCRotateSize
+		}
+	}
+
+	if c.ObeyRobots && c.robots == nil {
+		c.robots = newRobotsGate(c.client)
+	}
 
 	c.programTime = time.Now()
 	c.numberOfURLSParsed = 0
+	atomic.StoreInt32(&c.stopping, 0)
 	it := 0
-	go c.contantlyPrintStats()
-	for {
+
+	if c.Silent {
+		// no stats output at all
+	} else if c.NoProgress {
+		go c.contantlyPrintStats()
+	} else {
+		c.bar = pb.New(c.numToDo + c.numDone + c.numTrash)
+		c.bar.ShowSpeed = true
+		c.bar.ShowTimeLeft = true
+		c.bar.Start()
+		defer func() {
+			c.bar.Finish()
+			c.bar = nil
+		}()
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sigDone := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigCh:
+				if atomic.CompareAndSwapInt32(&c.stopping, 0, 1) {
+					c.log.Info("Received interrupt, finishing in-flight fetches before exiting")
+				} else {
+					c.log.Warn("Received second interrupt, forcing exit")
+					os.Exit(1)
+				}
+			case <-sigDone:
+				return
+			}
+		}
+	}()
+	defer close(sigDone)
+	defer signal.Stop(sigCh)
+
+	for atomic.LoadInt32(&c.stopping) == 0 {
 		it++
 		linksToDo, err := c.conn.Pop("todo", c.MaxNumberWorkers)
 		if err != nil {
@@ -386,24 +626,23 @@ func (c *Crawler) downloadOrCrawl(download bool) error {
 		if len(linksToDo) == 0 {
 			break
 		}
-		for url, numTriesStr := range linksToDo {
-			numTries, err := strconv.Atoi(numTriesStr)
-			if err != nil {
-				return err
-			}
+		for url, rawEntry := range linksToDo {
+			entry := decodeQueueEntry(rawEntry)
 			c.wg.Add(1)
-			go c.downloadOrCrawlLink(url, numTries, download)
+			go c.downloadOrCrawlLink(url, entry, download)
 		}
-		c.wg.Wait()
+		c.waitForWorkers(c.batchWaitTimeout(len(linksToDo)))
 
 		if math.Mod(float64(it), 100) == 0 {
 			// reload the configuration
 			fmt.Println("Reloading the HTTP pool")
-			c.client = &http.Client{Transport: tr}
+			c.client = &http.Client{Transport: tr, Timeout: fetchTimeout}
 		}
 	}
 	c.numToDo = 0
-	c.printStats()
+	if !c.Silent && c.NoProgress {
+		c.printStats()
+	}
 	return nil
 }
 
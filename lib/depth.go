@@ -0,0 +1,37 @@
+package crawler
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// queueEntry is the value stored in the todo/done/trash buckets for
+// each URL: how many times it has been tried, how deep it is in the
+// crawl tree relative to the seed (the seed is depth 0), the URL that
+// discovered it, and, for trashed entries, why it was trashed.
+type queueEntry struct {
+	Tries  int    `json:"tries"`
+	Depth  int    `json:"depth"`
+	Parent string `json:"parent,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// encodeQueueEntry serializes e as the JSON blob stored in BoltDB.
+func encodeQueueEntry(e queueEntry) string {
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+// decodeQueueEntry parses a stored value. Legacy databases store a bare
+// integer try count with no depth/parent information; those are
+// decoded with Depth 0 and an empty Parent.
+func decodeQueueEntry(s string) queueEntry {
+	var e queueEntry
+	if err := json.Unmarshal([]byte(s), &e); err == nil {
+		return e
+	}
+	if tries, err := strconv.Atoi(s); err == nil {
+		return queueEntry{Tries: tries}
+	}
+	return queueEntry{}
+}
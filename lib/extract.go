@@ -0,0 +1,205 @@
+package crawler
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jackdanger/collectlinks"
+)
+
+// LinkExtractor pulls outbound link candidates out of a fetched
+// resource. base is the URL the resource was fetched from, used to
+// resolve relative links.
+type LinkExtractor interface {
+	Extract(resp *http.Response, body io.Reader, base *url.URL) ([]string, error)
+}
+
+// RegisterExtractor associates a LinkExtractor with a Content-Type
+// prefix (e.g. "text/html", "text/css"). Extractors are tried in the
+// order their prefixes match resp.Header.Get("Content-Type"); the
+// first registered prefix to match wins.
+func (c *Crawler) RegisterExtractor(contentTypePrefix string, e LinkExtractor) {
+	if c.extractors == nil {
+		c.extractors = make(map[string]LinkExtractor)
+	}
+	c.extractors[contentTypePrefix] = e
+	c.extractorOrder = append(c.extractorOrder, contentTypePrefix)
+}
+
+// extractorFor returns the registered LinkExtractor whose prefix
+// matches contentType, or nil if none do.
+func (c *Crawler) extractorFor(contentType string) LinkExtractor {
+	for _, prefix := range c.extractorOrder {
+		if strings.HasPrefix(contentType, prefix) {
+			return c.extractors[prefix]
+		}
+	}
+	return nil
+}
+
+// registerDefaultExtractors wires up the built-in HTML, CSS, and
+// sitemap extractors. Called once from New. robots.txt Sitemap:
+// directives are not handled here but by robotsGate.
+func (c *Crawler) registerDefaultExtractors() {
+	c.RegisterExtractor("text/html", &htmlExtractor{})
+	c.RegisterExtractor("text/css", &cssExtractor{})
+	c.RegisterExtractor("application/xml", &sitemapExtractor{})
+	c.RegisterExtractor("text/xml", &sitemapExtractor{})
+}
+
+// resolveLink parses raw and resolves it against base, the URL of the
+// resource it was found in (not the crawl's site-wide BaseURL — e.g. a
+// stylesheet's own url(...) targets are relative to the stylesheet,
+// not the site root). Returns ok=false for links that don't parse.
+func resolveLink(base *url.URL, raw string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", false
+	}
+	return base.ResolveReference(u).String(), true
+}
+
+// assetExtensions are file extensions downloadOrCrawlLink's same-origin
+// filter exempts: images, scripts, stylesheets, and fonts are routinely
+// served from a separate CDN or asset subdomain than the page that
+// references them, so filtering them out by BaseURL substring would
+// silently drop most of what an asset-rich site has to offer.
+var assetExtensions = map[string]bool{
+	".css":   true,
+	".js":    true,
+	".png":   true,
+	".jpg":   true,
+	".jpeg":  true,
+	".gif":   true,
+	".svg":   true,
+	".ico":   true,
+	".webp":  true,
+	".woff":  true,
+	".woff2": true,
+	".ttf":   true,
+	".eot":   true,
+}
+
+// isAssetLink reports whether link's path extension matches a known
+// asset type (see assetExtensions).
+func isAssetLink(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	return assetExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// htmlExtractor extracts links from <a>, <img>, <script>, <link>,
+// <source srcset>, and <meta refresh> tags.
+type htmlExtractor struct{}
+
+var metaRefreshRe = regexp.MustCompile(`(?i)url=([^;]+)`)
+
+func (h *htmlExtractor) Extract(resp *http.Response, body io.Reader, base *url.URL) ([]string, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	found := collectlinks.All(strings.NewReader(string(raw)))
+
+	doc := string(raw)
+	for _, re := range []*regexp.Regexp{imgSrcRe, scriptSrcRe, linkHrefRe, sourceSrcsetRe} {
+		for _, m := range re.FindAllStringSubmatch(doc, -1) {
+			found = append(found, m[1])
+		}
+	}
+	for _, m := range metaRefreshTagRe.FindAllStringSubmatch(doc, -1) {
+		if sub := metaRefreshRe.FindStringSubmatch(m[1]); sub != nil {
+			found = append(found, strings.Trim(sub[1], `"'`))
+		}
+	}
+
+	var links []string
+	for _, raw := range found {
+		if link, ok := resolveLink(base, raw); ok {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+var (
+	imgSrcRe         = regexp.MustCompile(`(?i)<img[^>]+src=["']?([^"'\s>]+)`)
+	scriptSrcRe      = regexp.MustCompile(`(?i)<script[^>]+src=["']?([^"'\s>]+)`)
+	linkHrefRe       = regexp.MustCompile(`(?i)<link[^>]+href=["']?([^"'\s>]+)`)
+	sourceSrcsetRe   = regexp.MustCompile(`(?i)<source[^>]+srcset=["']?([^"'\s>]+)`)
+	metaRefreshTagRe = regexp.MustCompile(`(?i)<meta[^>]+http-equiv=["']?refresh["']?[^>]*content=["']([^"']+)["']`)
+)
+
+// cssExtractor extracts url(...) and @import targets from stylesheets.
+type cssExtractor struct{}
+
+var cssURLRe = regexp.MustCompile(`(?i)(?:background[^:]*:.*?)?url\(["']?([^'"\)]+)["']?\)|@import\s+["']([^'"]+)["']`)
+
+func (cs *cssExtractor) Extract(resp *http.Response, body io.Reader, base *url.URL) ([]string, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, m := range cssURLRe.FindAllStringSubmatch(string(raw), -1) {
+		target := m[1]
+		if target == "" {
+			target = m[2]
+		}
+		if target == "" {
+			continue
+		}
+		if link, ok := resolveLink(base, target); ok {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+// sitemapExtractor seeds new URLs from <loc> entries in a sitemap.xml.
+type sitemapExtractor struct{}
+
+var sitemapLocRe = regexp.MustCompile(`(?i)<loc>\s*([^<\s]+)\s*</loc>`)
+
+func (s *sitemapExtractor) Extract(resp *http.Response, body io.Reader, base *url.URL) ([]string, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, m := range sitemapLocRe.FindAllStringSubmatch(string(raw), -1) {
+		if link, ok := resolveLink(base, m[1]); ok {
+			links = append(links, link)
+		}
+	}
+	sort.Strings(links)
+	return links, nil
+}
+
+var sitemapDirectiveRe = regexp.MustCompile(`(?i)^Sitemap:\s*(\S+)`)
+
+// parseRobotsSitemapDirectives extracts Sitemap: directive targets from
+// a robots.txt body, resolved against that host's robots.txt URL. Used
+// by robotsGate, which fetches robots.txt directly rather than through
+// the Content-Type-dispatched extractor pipeline above.
+func parseRobotsSitemapDirectives(raw []byte, base *url.URL) []string {
+	var links []string
+	for _, line := range strings.Split(string(raw), "\n") {
+		m := sitemapDirectiveRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		if link, ok := resolveLink(base, m[1]); ok {
+			links = append(links, link)
+		}
+	}
+	return links
+}
@@ -0,0 +1,183 @@
+package crawler
+
+import (
+	"io"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+// defaultUserAgent is used for both the robots.txt check and the
+// outbound request headers when Crawler.UserAgent is unset.
+const defaultUserAgent = "gogetlinks"
+
+// hostLimiter is a simple token bucket enforcing at most one fetch per
+// interval for a single host.
+type hostLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (h *hostLimiter) Wait() {
+	h.mu.Lock()
+	now := time.Now()
+	if now.Before(h.next) {
+		wait := h.next.Sub(now)
+		h.next = h.next.Add(h.interval)
+		h.mu.Unlock()
+		time.Sleep(wait)
+		return
+	}
+	h.next = now.Add(h.interval)
+	h.mu.Unlock()
+}
+
+// robotsGate fetches and caches robots.txt per host and enforces a
+// per-host rate limit, independent of MaxNumberWorkers.
+type robotsGate struct {
+	mu              sync.RWMutex
+	robots          map[string]*robotstxt.RobotsData
+	limiters        map[string]*hostLimiter
+	pendingSitemaps map[string][]string
+	client          *http.Client
+}
+
+func newRobotsGate(client *http.Client) *robotsGate {
+	return &robotsGate{
+		robots:          make(map[string]*robotstxt.RobotsData),
+		limiters:        make(map[string]*hostLimiter),
+		pendingSitemaps: make(map[string][]string),
+		client:          client,
+	}
+}
+
+// robotsFor returns the cached RobotsData for host, fetching it if
+// this is the first time host has been seen. Any Sitemap: directives
+// in a freshly fetched robots.txt are queued for takeSitemaps.
+func (g *robotsGate) robotsFor(scheme, host string) *robotstxt.RobotsData {
+	g.mu.RLock()
+	data, ok := g.robots[host]
+	g.mu.RUnlock()
+	if ok {
+		return data
+	}
+
+	resp, err := g.client.Get(scheme + "://" + host + "/robots.txt")
+	if err != nil {
+		g.mu.Lock()
+		g.robots[host] = nil
+		g.mu.Unlock()
+		return nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		g.mu.Lock()
+		g.robots[host] = nil
+		g.mu.Unlock()
+		return nil
+	}
+
+	parsed, err := robotstxt.FromStatusAndBytes(resp.StatusCode, body)
+	if err != nil {
+		parsed = nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if data, ok := g.robots[host]; ok {
+		// Another goroutine already fetched and cached host's robots.txt
+		// while we were mid-fetch; keep its result so we don't duplicate
+		// the pendingSitemaps queue below.
+		return data
+	}
+	if sitemaps := parseRobotsSitemapDirectives(body, resp.Request.URL); len(sitemaps) > 0 {
+		g.pendingSitemaps[host] = append(g.pendingSitemaps[host], sitemaps...)
+	}
+	g.robots[host] = parsed
+	return parsed
+}
+
+// takeSitemaps returns and clears any Sitemap: directive targets
+// discovered in rawurl's host's robots.txt, if one has been fetched.
+func (g *robotsGate) takeSitemaps(rawurl string) []string {
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sitemaps := g.pendingSitemaps[u.Host]
+	delete(g.pendingSitemaps, u.Host)
+	return sitemaps
+}
+
+// limiterFor returns the hostLimiter for host, honoring crawlDelay (as
+// a lower bound) if it is longer than the configured qps interval.
+func (g *robotsGate) limiterFor(host string, qps float64, crawlDelay time.Duration) *hostLimiter {
+	interval := crawlDelay
+	if qps > 0 {
+		byQPS := time.Duration(float64(time.Second) / qps)
+		if byQPS > interval {
+			interval = byQPS
+		}
+	}
+	if interval <= 0 {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	l, ok := g.limiters[host]
+	if !ok || l.interval != interval {
+		l = &hostLimiter{interval: interval}
+		g.limiters[host] = l
+	}
+	return l
+}
+
+// maxInterval returns the longest per-host fetch interval currently
+// enforced across all hosts seen so far (e.g. from a robots.txt
+// Crawl-Delay), or 0 if none has been observed yet.
+func (g *robotsGate) maxInterval() time.Duration {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var max time.Duration
+	for _, l := range g.limiters {
+		if l.interval > max {
+			max = l.interval
+		}
+	}
+	return max
+}
+
+// allow reports whether userAgent may fetch rawurl according to the
+// cached robots.txt for its host, and blocks until the per-host rate
+// limit permits the fetch. It always returns true when robots.txt
+// could not be retrieved or parsed, per the usual crawler convention.
+func (g *robotsGate) allow(rawurl, userAgent string, qps float64) bool {
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return true
+	}
+
+	data := g.robotsFor(u.Scheme, u.Host)
+
+	crawlDelay := time.Duration(0)
+	allowed := true
+	if data != nil {
+		group := data.FindGroup(userAgent)
+		allowed = group.Test(u.Path)
+		crawlDelay = group.CrawlDelay
+	}
+
+	if limiter := g.limiterFor(u.Host, qps, crawlDelay); limiter != nil {
+		limiter.Wait()
+	}
+
+	return allowed
+}
@@ -0,0 +1,63 @@
+package crawler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Store is the persistence layer the crawler queues its todo/done/trash
+// buckets through. It is the same set of operations Crawler used to
+// call directly on a *connect.Connection, pulled out so other backends
+// can be swapped in.
+type Store interface {
+	// CreateBuckets ensures each named bucket exists.
+	CreateBuckets(buckets []string) error
+	// Post upserts the given key/value pairs into bucket.
+	Post(bucket string, keyValues map[string]string) error
+	// Pop removes and returns up to n key/value pairs from bucket.
+	Pop(bucket string, n int) (map[string]string, error)
+	// GetKeys returns every key currently in bucket.
+	GetKeys(bucket string) ([]string, error)
+	// GetAll returns every key/value pair currently in bucket.
+	GetAll(bucket string) (map[string]string, error)
+	// HasKeys reports, for each of keys, whether it exists in any of buckets.
+	HasKeys(buckets []string, keys []string) (map[string]bool, error)
+}
+
+// openStore dispatches a storage URI to the matching Store
+// implementation:
+//
+//	bolt://path/to.db            embedded, single-file BoltDB, no server
+//	boltserver://host:port/name  the remote boltdb-server client
+//	mem://                       in-memory, for tests and benchmarks
+//
+// A bare host:port with no scheme is treated as boltserver:// for
+// backwards compatibility with callers built against the old
+// connect.Open(boltdbserver, name) API.
+func openStore(uri string, name string) (Store, error) {
+	switch {
+	case strings.HasPrefix(uri, "bolt://"):
+		return newBoltStore(strings.TrimPrefix(uri, "bolt://"))
+	case strings.HasPrefix(uri, "mem://"):
+		return newMemStore(), nil
+	case strings.HasPrefix(uri, "boltserver://"):
+		server := strings.TrimPrefix(uri, "boltserver://")
+		if i := strings.Index(server, "/"); i >= 0 {
+			if server[i+1:] != "" {
+				name = server[i+1:]
+			}
+			server = server[:i]
+		}
+		return newRemoteStore(server, name)
+	case uri == "":
+		return newMemStore(), nil
+	default:
+		return newRemoteStore(uri, name)
+	}
+}
+
+// errBucketNotFound is returned by in-memory/embedded stores when an
+// operation references a bucket that CreateBuckets was never called for.
+func errBucketNotFound(bucket string) error {
+	return fmt.Errorf("bucket %s not found", bucket)
+}
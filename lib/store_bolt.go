@@ -0,0 +1,121 @@
+package crawler
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore is a Store backed by a single embedded BoltDB file, with no
+// external server required. This is the common case for a one-off
+// crawl.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func newBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) CreateBuckets(buckets []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Post(bucket string, keyValues map[string]string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return errBucketNotFound(bucket)
+		}
+		for k, v := range keyValues {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Pop(bucket string, n int) (map[string]string, error) {
+	popped := make(map[string]string)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return errBucketNotFound(bucket)
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && len(popped) < n; k, v = c.Next() {
+			popped[string(k)] = string(v)
+		}
+		for k := range popped {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return popped, err
+}
+
+func (s *boltStore) GetKeys(bucket string) ([]string, error) {
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return errBucketNotFound(bucket)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func (s *boltStore) GetAll(bucket string) (map[string]string, error) {
+	all := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return errBucketNotFound(bucket)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			all[string(k)] = string(v)
+			return nil
+		})
+	})
+	return all, err
+}
+
+func (s *boltStore) HasKeys(buckets []string, keys []string) (map[string]bool, error) {
+	has := make(map[string]bool)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			found := false
+			for _, bucket := range buckets {
+				b := tx.Bucket([]byte(bucket))
+				if b == nil {
+					continue
+				}
+				if b.Get([]byte(key)) != nil {
+					found = true
+					break
+				}
+			}
+			has[key] = found
+		}
+		return nil
+	})
+	return has, err
+}
@@ -0,0 +1,107 @@
+package crawler
+
+import "sync"
+
+// memStore is an in-memory Store, useful for tests and for benchmarking
+// the crawler without any disk or network I/O.
+type memStore struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]string
+}
+
+func newMemStore() Store {
+	return &memStore{buckets: make(map[string]map[string]string)}
+}
+
+func (s *memStore) CreateBuckets(buckets []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, bucket := range buckets {
+		if _, ok := s.buckets[bucket]; !ok {
+			s.buckets[bucket] = make(map[string]string)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Post(bucket string, keyValues map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return errBucketNotFound(bucket)
+	}
+	for k, v := range keyValues {
+		b[k] = v
+	}
+	return nil
+}
+
+func (s *memStore) Pop(bucket string, n int) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, errBucketNotFound(bucket)
+	}
+	popped := make(map[string]string)
+	for k, v := range b {
+		if len(popped) >= n {
+			break
+		}
+		popped[k] = v
+	}
+	for k := range popped {
+		delete(b, k)
+	}
+	return popped, nil
+}
+
+func (s *memStore) GetKeys(bucket string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, errBucketNotFound(bucket)
+	}
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *memStore) GetAll(bucket string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, errBucketNotFound(bucket)
+	}
+	all := make(map[string]string, len(b))
+	for k, v := range b {
+		all[k] = v
+	}
+	return all, nil
+}
+
+func (s *memStore) HasKeys(buckets []string, keys []string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	has := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		found := false
+		for _, bucket := range buckets {
+			b, ok := s.buckets[bucket]
+			if !ok {
+				continue
+			}
+			if _, ok := b[key]; ok {
+				found = true
+				break
+			}
+		}
+		has[key] = found
+	}
+	return has, nil
+}
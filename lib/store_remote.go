@@ -0,0 +1,43 @@
+package crawler
+
+import (
+	"github.com/schollz/boltdb-server/connect"
+)
+
+// remoteStore is a Store backed by a remote boltdb-server instance. It
+// is the original, and until now only, backend the crawler supported.
+type remoteStore struct {
+	conn *connect.Connection
+}
+
+func newRemoteStore(boltdbserver string, name string) (Store, error) {
+	conn, err := connect.Open(boltdbserver, name)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteStore{conn: conn}, nil
+}
+
+func (s *remoteStore) CreateBuckets(buckets []string) error {
+	return s.conn.CreateBuckets(buckets)
+}
+
+func (s *remoteStore) Post(bucket string, keyValues map[string]string) error {
+	return s.conn.Post(bucket, keyValues)
+}
+
+func (s *remoteStore) Pop(bucket string, n int) (map[string]string, error) {
+	return s.conn.Pop(bucket, n)
+}
+
+func (s *remoteStore) GetKeys(bucket string) ([]string, error) {
+	return s.conn.GetKeys(bucket)
+}
+
+func (s *remoteStore) GetAll(bucket string) (map[string]string, error) {
+	return s.conn.GetAll(bucket)
+}
+
+func (s *remoteStore) HasKeys(buckets []string, keys []string) (map[string]bool, error) {
+	return s.conn.HasKeys(buckets, keys)
+}
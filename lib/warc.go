@@ -0,0 +1,159 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultWARCRotateSize is the default size, in bytes, at which a WARC
+// file is rotated into a new segment.
+const defaultWARCRotateSize = 1 << 30 // 1 GiB
+
+// WARCWriter appends WARC 1.1 records to a sequence of gzip-per-record
+// files, rotating to a new segment once the current one reaches
+// RotateSize bytes.
+type WARCWriter struct {
+	// Dir is the directory the crawl-NNNNN.warc.gz segments are written to.
+	Dir string
+	// RotateSize is the size, in bytes, at which the current segment is
+	// closed and a new one started. It is checked after each record pair,
+	// so a segment can end up larger than RotateSize by up to one pair.
+	// Zero uses defaultWARCRotateSize.
+	RotateSize int64
+
+	mu      sync.Mutex
+	f       *os.File
+	written int64
+	segment int
+}
+
+// NewWARCWriter creates a WARCWriter that writes crawl-NNNNN.warc.gz
+// segments into dir.
+func NewWARCWriter(dir string) *WARCWriter {
+	return &WARCWriter{Dir: dir, RotateSize: defaultWARCRotateSize}
+}
+
+func (w *WARCWriter) rotateSize() int64 {
+	if w.RotateSize <= 0 {
+		return defaultWARCRotateSize
+	}
+	return w.RotateSize
+}
+
+// segmentName returns the filename for the current segment.
+func (w *WARCWriter) segmentName() string {
+	return fmt.Sprintf("crawl-%05d.warc.gz", w.segment+1)
+}
+
+// openCurrent opens (creating if necessary) the current segment file,
+// appending to it if it already exists.
+func (w *WARCWriter) openCurrent() error {
+	if w.f != nil {
+		return nil
+	}
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(w.Dir, w.segmentName())
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.written = fi.Size()
+	return nil
+}
+
+// WriteExchange writes the request/response record pair for a single
+// fetch of url.
+func (w *WARCWriter) WriteExchange(url string, req *http.Request, resp *http.Response, body []byte, fetchedAt time.Time) error {
+	reqDump, err := httputil.DumpRequestOut(req, false)
+	if err != nil {
+		return err
+	}
+
+	respHeader, err := httputil.DumpResponse(resp, false)
+	if err != nil {
+		return err
+	}
+	respBlock := append(respHeader, body...)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.openCurrent(); err != nil {
+		return err
+	}
+
+	requestRecordID := newWARCRecordID()
+	if err := w.writeRecord("request", requestRecordID, url, "application/http; msgtype=request", reqDump, fetchedAt); err != nil {
+		return err
+	}
+	if err := w.writeRecord("response", newWARCRecordID(), url, "application/http; msgtype=response", respBlock, fetchedAt); err != nil {
+		return err
+	}
+
+	if w.written >= w.rotateSize() {
+		w.f.Close()
+		w.f = nil
+		w.segment++
+	}
+	return nil
+}
+
+// writeRecord gzips and appends a single WARC record to the current
+// segment. Caller must hold w.mu.
+func (w *WARCWriter) writeRecord(recordType, recordID, targetURI, contentType string, block []byte, ts time.Time) error {
+	header := fmt.Sprintf("WARC/1.1\r\n"+
+		"WARC-Type: %s\r\n"+
+		"WARC-Record-ID: <urn:uuid:%s>\r\n"+
+		"WARC-Date: %s\r\n"+
+		"WARC-Target-URI: %s\r\n"+
+		"Content-Type: %s\r\n"+
+		"Content-Length: %d\r\n"+
+		"\r\n",
+		recordType, recordID, ts.UTC().Format(time.RFC3339Nano), targetURI, contentType, len(block))
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(gz, header); err != nil {
+		return err
+	}
+	if _, err := gz.Write(block); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(gz, "\r\n\r\n"); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	n, err := w.f.Write(buf.Bytes())
+	w.written += int64(n)
+	return err
+}
+
+// newWARCRecordID returns a random UUID (v4) string suitable for use in a
+// WARC-Record-ID field.
+func newWARCRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}